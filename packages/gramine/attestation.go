@@ -0,0 +1,204 @@
+// Nonce and freshness binding for the quote-generation flow.
+//
+// A quote that only binds the caller's x25519 public key is replayable for
+// as long as that key is in use. This file adds caller- and server-issued
+// freshness material that gets folded into report_data[32:64], plus a
+// small LRU so bursts of identical (publicKey, nonce) requests don't each
+// pay for a fresh SGX quote.
+
+package main
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// challengeTTL is how long a server-issued challenge nonce remains
+	// redeemable via GET /challenge + POST /quote{challenge_id}.
+	challengeTTL = 30 * time.Second
+
+	// challengeSweepInterval bounds how long expired challenges linger in
+	// memory before being swept.
+	challengeSweepInterval = time.Minute
+
+	// quoteCacheCapacity bounds the (publicKey, nonce) -> quote LRU.
+	quoteCacheCapacity = 256
+
+	// quoteCacheTTL bounds how long a cached quote may be served before a
+	// fresh one is generated, so the cache only smooths out bursts rather
+	// than serving indefinitely stale quotes.
+	quoteCacheTTL = 10 * time.Second
+)
+
+// boundNonce folds caller- or challenge-supplied freshness material into
+// the 32 bytes that go in report_data[32:64]: zero-padded if it fits,
+// hashed down if it doesn't.
+func boundNonce(nonce []byte) [32]byte {
+	var out [32]byte
+	if len(nonce) > 32 {
+		out = sha256.Sum256(nonce)
+		return out
+	}
+	copy(out[:], nonce)
+	return out
+}
+
+// challengeEntry is a single server-issued nonce awaiting redemption.
+type challengeEntry struct {
+	nonce     []byte
+	expiresAt time.Time
+}
+
+// challengeStore issues short-lived freshness challenges and redeems them
+// exactly once, so a quote generated against a challenge can't be replayed
+// under a second challenge_id.
+type challengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]challengeEntry
+}
+
+func newChallengeStore() *challengeStore {
+	cs := &challengeStore{challenges: make(map[string]challengeEntry)}
+
+	go func() {
+		ticker := time.NewTicker(challengeSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cs.sweep()
+		}
+	}()
+
+	return cs
+}
+
+// issue mints a random 32-byte nonce and a random challenge ID, valid for
+// challengeTTL.
+func (cs *challengeStore) issue() (id string, nonce []byte, err error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate challenge id: %w", err)
+	}
+	nonce = make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate challenge nonce: %w", err)
+	}
+
+	id = hex.EncodeToString(idBytes)
+
+	cs.mu.Lock()
+	cs.challenges[id] = challengeEntry{nonce: nonce, expiresAt: time.Now().Add(challengeTTL)}
+	cs.mu.Unlock()
+
+	return id, nonce, nil
+}
+
+// redeem looks up and removes a challenge ID, so it can't be reused. It
+// reports ok=false if the ID is unknown, already redeemed, or expired.
+func (cs *challengeStore) redeem(id string) (nonce []byte, ok bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	entry, exists := cs.challenges[id]
+	if !exists {
+		return nil, false
+	}
+	delete(cs.challenges, id)
+
+	if time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.nonce, true
+}
+
+func (cs *challengeStore) sweep() {
+	now := time.Now()
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for id, entry := range cs.challenges {
+		if now.After(entry.expiresAt) {
+			delete(cs.challenges, id)
+		}
+	}
+}
+
+// quoteCacheKey identifies a quote by the inputs that determine its
+// report_data, so identical bursts hit the cache instead of re-entering
+// the enclave.
+func quoteCacheKey(publicKey, nonce []byte) string {
+	return base64.StdEncoding.EncodeToString(publicKey) + "|" + base64.StdEncoding.EncodeToString(nonce)
+}
+
+type quoteCacheEntry struct {
+	key       string
+	data      *sgxQuoteData
+	expiresAt time.Time
+}
+
+// quoteCache is a small LRU, keyed by (publicKey, nonce), so that a burst
+// of requests for the same binding doesn't each pay for a fresh SGX quote
+// (EENTER/EEXIT is expensive, and the quote service is already rate
+// limited around it for that reason).
+type quoteCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List // front = most recently used
+	entries  map[string]*list.Element
+}
+
+func newQuoteCache(capacity int, ttl time.Duration) *quoteCache {
+	return &quoteCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *quoteCache) get(key string) (*sgxQuoteData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*quoteCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.data, true
+}
+
+func (c *quoteCache) put(key string, data *sgxQuoteData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &quoteCacheEntry{key: key, data: data, expiresAt: time.Now().Add(c.ttl)}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(&quoteCacheEntry{key: key, data: data, expiresAt: time.Now().Add(c.ttl)})
+	c.entries[key] = elem
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*quoteCacheEntry).key)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// TEE backend abstraction.
+//
+// sgx-entrypoint originally assumed it only ever ran under Gramine-SGX.
+// The JSON quote shape it serves ("same format as TDX service") is shared
+// with a parallel TDX quote service, so the actual quote-producing code
+// belongs behind an interface: one binary, selected at startup, that can
+// run under Gramine-SGX, TDX, or (for CI / local dev without real
+// hardware) a deterministic mock.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// TEEBackend produces attestation quotes for whatever hardware (or mock)
+// is backing the current process.
+type TEEBackend interface {
+	// Kind identifies the backend, e.g. "sgx", "tdx", "mock".
+	Kind() string
+	// Available reports whether this backend's attestation surface is
+	// actually usable in the current environment.
+	Available() bool
+	// Quote generates a quote over the given 64-byte report data.
+	Quote(reportData [64]byte) ([]byte, error)
+	// CollateralBundle returns the verification collateral (cert chain,
+	// TCB info, CRLs, ...) associated with a previously generated quote.
+	CollateralBundle(quote []byte) ([]byte, error)
+	// AttestationType reports the backend's attestation flavor, e.g. "dcap"
+	// for SGX DCAP, so operators can tell from /healthz and /debug/vars
+	// what kind of quote they're actually getting.
+	AttestationType() string
+}
+
+// errCollateralUnavailable is returned by backends that don't (yet) know
+// how to produce collateral for a quote.
+var errCollateralUnavailable = fmt.Errorf("collateral not available for this backend")
+
+// activeBackend is the TEEBackend selected at startup. It's a package
+// variable (rather than threaded through every call) because it's fixed
+// for the process lifetime and read from both the HTTP handlers and the
+// metrics endpoint.
+var activeBackend TEEBackend
+
+// selectBackend picks a backend, honoring TEE_BACKEND=sgx|tdx|mock if set,
+// otherwise probing in order: SGX, TDX, mock.
+func selectBackend() TEEBackend {
+	switch os.Getenv("TEE_BACKEND") {
+	case "sgx":
+		return &sgxBackend{}
+	case "tdx":
+		return &tdxBackend{}
+	case "mock":
+		return &mockBackend{}
+	}
+
+	if (&sgxBackend{}).Available() {
+		return &sgxBackend{}
+	}
+	if (&tdxBackend{}).Available() {
+		return &tdxBackend{}
+	}
+	return &mockBackend{}
+}
+
+// sgxBackend generates quotes via Gramine's /dev/attestation pseudo-filesystem.
+type sgxBackend struct{}
+
+func (b *sgxBackend) Kind() string { return "sgx" }
+
+func (b *sgxBackend) Available() bool {
+	return isGramineEnclave()
+}
+
+func (b *sgxBackend) Quote(reportData [64]byte) ([]byte, error) {
+	if !b.Available() {
+		return nil, &quoteError{"not-in-enclave", fmt.Errorf("not running inside a Gramine SGX enclave")}
+	}
+
+	if err := os.WriteFile(attestationUserReportData, reportData[:], 0644); err != nil {
+		return nil, &quoteError{"write-user-report-data", fmt.Errorf("failed to write report_data: %w", err)}
+	}
+
+	quote, err := os.ReadFile(attestationQuote)
+	if err != nil {
+		return nil, &quoteError{"read-quote", fmt.Errorf("failed to read quote: %w", err)}
+	}
+
+	return quote, nil
+}
+
+// CollateralBundle is implemented in collateral.go.
+
+func (b *sgxBackend) AttestationType() string {
+	return getAttestationType()
+}
+
+// tdxBackend generates quotes on TDX hosts, via the configfs TSM report
+// interface (/sys/kernel/config/tsm/report/*) or the legacy /dev/tdx_guest
+// device node, whichever is present.
+//
+// TODO: only availability probing is implemented so far; Quote/CollateralBundle
+// need a real TDX host to exercise the configfs report-creation flow
+// (mkdir a report instance, write reportdata/inblob, read outblob).
+type tdxBackend struct{}
+
+const (
+	tdxGuestDevice  = "/dev/tdx_guest"
+	tdxTSMReportDir = "/sys/kernel/config/tsm/report"
+)
+
+func (b *tdxBackend) Kind() string { return "tdx" }
+
+func (b *tdxBackend) Available() bool {
+	if _, err := os.Stat(tdxGuestDevice); err == nil {
+		return true
+	}
+	if info, err := os.Stat(tdxTSMReportDir); err == nil && info.IsDir() {
+		return true
+	}
+	return false
+}
+
+func (b *tdxBackend) Quote(reportData [64]byte) ([]byte, error) {
+	return nil, &quoteError{"not-implemented", fmt.Errorf("TDX quote generation is not yet implemented")}
+}
+
+func (b *tdxBackend) CollateralBundle(quote []byte) ([]byte, error) {
+	return nil, errCollateralUnavailable
+}
+
+// AttestationType returns "" until TDX quote generation is implemented:
+// there's no attestation flavor to report for a backend that can't yet
+// produce a quote.
+func (b *tdxBackend) AttestationType() string {
+	return ""
+}
+
+// mockBackend is a deterministic stand-in for CI and local dev without
+// real TEE hardware. It always succeeds and returns a quote made of a
+// recognizable magic prefix followed by the 64 bytes of report data, so
+// downstream verification code paths can be exercised end-to-end without
+// an enclave.
+type mockBackend struct{}
+
+// mockQuoteMagic prefixes every mock quote so callers can recognize (and
+// reject, in any production verifier) a quote that didn't come from real
+// hardware.
+var mockQuoteMagic = []byte("TEEKIT-MOCK-QUOTE")
+
+func (b *mockBackend) Kind() string    { return "mock" }
+func (b *mockBackend) Available() bool { return true }
+
+func (b *mockBackend) Quote(reportData [64]byte) ([]byte, error) {
+	quote := make([]byte, 0, len(mockQuoteMagic)+len(reportData))
+	quote = append(quote, mockQuoteMagic...)
+	quote = append(quote, reportData[:]...)
+	return quote, nil
+}
+
+func (b *mockBackend) CollateralBundle(quote []byte) ([]byte, error) {
+	return []byte(`{"mock":true}`), nil
+}
+
+func (b *mockBackend) AttestationType() string { return "mock" }
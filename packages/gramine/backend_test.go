@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// TestSelectBackendHonorsTEEBackendEnv covers the dispatch table in
+// selectBackend: TEE_BACKEND should pin the backend regardless of what
+// would otherwise be probed.
+func TestSelectBackendHonorsTEEBackendEnv(t *testing.T) {
+	cases := []struct {
+		env  string
+		kind string
+	}{
+		{"sgx", "sgx"},
+		{"tdx", "tdx"},
+		{"mock", "mock"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.env, func(t *testing.T) {
+			t.Setenv("TEE_BACKEND", c.env)
+			backend := selectBackend()
+			if backend.Kind() != c.kind {
+				t.Errorf("selectBackend() with TEE_BACKEND=%s = %q, want %q", c.env, backend.Kind(), c.kind)
+			}
+		})
+	}
+}
+
+// TestSelectBackendProbesToMock covers the fallback path: outside a real
+// SGX or TDX environment (true of any machine running these tests),
+// probing with no TEE_BACKEND set should land on the mock backend.
+func TestSelectBackendProbesToMock(t *testing.T) {
+	t.Setenv("TEE_BACKEND", "")
+	backend := selectBackend()
+	if backend.Kind() != "mock" {
+		t.Errorf("selectBackend() with no TEE_BACKEND = %q, want %q", backend.Kind(), "mock")
+	}
+}
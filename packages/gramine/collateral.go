@@ -0,0 +1,283 @@
+// DCAP collateral bundling.
+//
+// A raw DCAP quote isn't independently verifiable: a verifier also needs
+// the PCK certificate chain, TCB info, QE identity, and CRLs that the
+// quote's signature chains up to. This file adds an optional collateral
+// bundle to /quote, sourced from Gramine's attestation surface when
+// present, or fetched from an Intel PCCS / Trusted Services cache and
+// cached in-process (collateral rotates rarely; quotes are frequent).
+//
+// Environment:
+//   PCCS_URL - Base URL of a PCCS / Trusted Services cache, e.g.
+//              https://pccs.example.com (default: none, collateral
+//              requests fail unless Gramine exposes collateral directly)
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// attestationQuoteCollateral is the Gramine pseudo-file carrying a
+	// pre-bundled collateral blob, where the runtime exposes one. Not all
+	// Gramine builds provide this; absence just means we fall back to PCCS.
+	attestationQuoteCollateral = "/dev/attestation/quote_collateral"
+
+	// collateralCacheTTL bounds how long a fetched/read collateral bundle
+	// is reused before going back to the source.
+	collateralCacheTTL = 6 * time.Hour
+
+	pccsRequestTimeout = 10 * time.Second
+)
+
+// collateralBundle is the DCAP verification collateral returned alongside
+// a quote, each field base64-encoded in the JSON response.
+type collateralBundle struct {
+	PCKCRLChain           []byte `json:"pck_crl_chain"`
+	PCKCRL                []byte `json:"pck_crl"`
+	TCBInfo               []byte `json:"tcb_info"`
+	TCBInfoIssuerChain    []byte `json:"tcb_info_issuer_chain"`
+	QEIdentity            []byte `json:"qe_identity"`
+	QEIdentityIssuerChain []byte `json:"qe_identity_issuer_chain"`
+	RootCACRL             []byte `json:"root_ca_crl"`
+}
+
+// collateralCacheEntry is a cached bundle along with when it was fetched.
+type collateralCacheEntry struct {
+	bundle    *collateralBundle
+	fetchedAt time.Time
+}
+
+// collateralCache caches bundles keyed by FMSPC (or a fixed key for
+// Gramine-provided collateral, which isn't FMSPC-specific).
+type collateralCache struct {
+	mu      sync.Mutex
+	entries map[string]collateralCacheEntry
+}
+
+func newCollateralCache() *collateralCache {
+	return &collateralCache{entries: make(map[string]collateralCacheEntry)}
+}
+
+func (c *collateralCache) get(key string) (*collateralBundle, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.fetchedAt) > collateralCacheTTL {
+		return nil, false
+	}
+	return entry.bundle, true
+}
+
+func (c *collateralCache) put(key string, bundle *collateralBundle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = collateralCacheEntry{bundle: bundle, fetchedAt: time.Now()}
+}
+
+// fmspcOID identifies the FMSPC field within the PCK certificate's SGX
+// extension (itself a SEQUENCE OF {id, value}, per Intel's PCK cert spec).
+var fmspcOID = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 4}
+var sgxExtensionOID = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1}
+
+type sgxExtensionField struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// extractFMSPC pulls the FMSPC out of the PCK certificate chain embedded
+// in a DCAP quote's certification data. DCAP quotes using cert type 5
+// carry the chain as concatenated PEM blocks, so we scan for those rather
+// than parsing the full quote structure.
+func extractFMSPC(quote []byte) (string, error) {
+	rest := quote
+	for {
+		block, remainder := pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		rest = remainder
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+
+		for _, ext := range cert.Extensions {
+			if !ext.Id.Equal(sgxExtensionOID) {
+				continue
+			}
+			var fields []sgxExtensionField
+			if _, err := asn1.Unmarshal(ext.Value, &fields); err != nil {
+				continue
+			}
+			for _, f := range fields {
+				if f.ID.Equal(fmspcOID) {
+					return fmt.Sprintf("%x", f.Value.Bytes), nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no PCK certificate with an FMSPC extension found in quote")
+}
+
+// readGramineCollateral reads a pre-bundled collateral blob from Gramine's
+// attestation surface, where the runtime exposes one.
+//
+// The expected format, matching the JSON shape this handler serves, is a
+// JSON object with base64-encoded fields; that's a reasonable contract for
+// a Gramine build or sidecar that wants to inject collateral without this
+// process talking to a PCCS itself.
+func readGramineCollateral() (*collateralBundle, error) {
+	data, err := os.ReadFile(attestationQuoteCollateral)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", attestationQuoteCollateral, err)
+	}
+
+	var bundle collateralBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid collateral at %s: %w", attestationQuoteCollateral, err)
+	}
+	return &bundle, nil
+}
+
+// pccsCollateralPaths are the Intel PCCS v4 API paths this client fetches
+// against, relative to PCCS_URL.
+const (
+	pccsPCKCRLPath     = "/sgx/certification/v4/pckcrl?ca=processor"
+	pccsTCBInfoPath    = "/sgx/certification/v4/tcb"
+	pccsQEIdentityPath = "/sgx/certification/v4/qe/identity"
+	pccsRootCACRLPath  = "/sgx/certification/v4/rootcacrl"
+
+	headerPCKCRLIssuerChain     = "SGX-PCK-CRL-Issuer-Chain"
+	headerTCBInfoIssuerChain    = "SGX-TCB-Info-Issuer-Chain"
+	headerQEIdentityIssuerChain = "SGX-Enclave-Identity-Issuer-Chain"
+)
+
+// fetchPCCSCollateral fetches collateral for the given FMSPC from a PCCS
+// (or Intel Trusted Services cache) at baseURL, following the v4 API.
+func fetchPCCSCollateral(baseURL, fmspc string) (*collateralBundle, error) {
+	client := &http.Client{Timeout: pccsRequestTimeout}
+
+	pckCRL, pckChain, err := pccsGet(client, baseURL+pccsPCKCRLPath, headerPCKCRLIssuerChain)
+	if err != nil {
+		return nil, fmt.Errorf("fetching PCK CRL: %w", err)
+	}
+
+	tcbInfo, tcbChain, err := pccsGet(client, fmt.Sprintf("%s%s?fmspc=%s", baseURL, pccsTCBInfoPath, fmspc), headerTCBInfoIssuerChain)
+	if err != nil {
+		return nil, fmt.Errorf("fetching TCB info: %w", err)
+	}
+
+	qeIdentity, qeChain, err := pccsGet(client, baseURL+pccsQEIdentityPath, headerQEIdentityIssuerChain)
+	if err != nil {
+		return nil, fmt.Errorf("fetching QE identity: %w", err)
+	}
+
+	// The root CA CRL isn't part of the v4 cert/tcb endpoints; best-effort
+	// fetch it from the cache too and tolerate its absence.
+	rootCACRL, _, _ := pccsGet(client, baseURL+pccsRootCACRLPath, "")
+
+	return &collateralBundle{
+		PCKCRL:                pckCRL,
+		PCKCRLChain:           pckChain,
+		TCBInfo:               tcbInfo,
+		TCBInfoIssuerChain:    tcbChain,
+		QEIdentity:            qeIdentity,
+		QEIdentityIssuerChain: qeChain,
+		RootCACRL:             rootCACRL,
+	}, nil
+}
+
+// pccsGet performs a single PCCS request, returning the body and the
+// named response header (typically an issuer cert chain), if any.
+func pccsGet(client *http.Client, url, issuerChainHeader string) (body, issuerChain []byte, err error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response from %s: %w", url, err)
+	}
+
+	if issuerChainHeader != "" {
+		if chain := resp.Header.Get(issuerChainHeader); chain != "" {
+			issuerChain = []byte(strings.TrimSpace(chain))
+		}
+	}
+
+	return body, issuerChain, nil
+}
+
+// sgxCollateralCache caches bundles across requests; collateral rotates
+// rarely enough that PCCS shouldn't be hit on every attestation.
+var sgxCollateralCache = newCollateralCache()
+
+// gramineCollateralCacheKey is used for collateral read directly off the
+// Gramine attestation surface, which isn't FMSPC-keyed.
+const gramineCollateralCacheKey = "gramine"
+
+// CollateralBundle returns the DCAP verification collateral for quote,
+// preferring a pre-bundled Gramine source and falling back to a
+// configured PCCS, caching either result in-process.
+func (b *sgxBackend) CollateralBundle(quote []byte) ([]byte, error) {
+	if bundle, ok := sgxCollateralCache.get(gramineCollateralCacheKey); ok {
+		return json.Marshal(bundle)
+	}
+
+	if bundle, err := readGramineCollateral(); err != nil {
+		log.Printf("[sgx-entrypoint] Gramine collateral unavailable: %v", err)
+	} else if bundle != nil {
+		sgxCollateralCache.put(gramineCollateralCacheKey, bundle)
+		return json.Marshal(bundle)
+	}
+
+	pccsURL := os.Getenv("PCCS_URL")
+	if pccsURL == "" {
+		return nil, errCollateralUnavailable
+	}
+
+	fmspc, err := extractFMSPC(quote)
+	if err != nil {
+		return nil, fmt.Errorf("extracting FMSPC from quote: %w", err)
+	}
+
+	if cached, ok := sgxCollateralCache.get(fmspc); ok {
+		return json.Marshal(cached)
+	}
+
+	bundle, err := fetchPCCSCollateral(strings.TrimRight(pccsURL, "/"), fmspc)
+	if err != nil {
+		return nil, err
+	}
+
+	sgxCollateralCache.put(fmspc, bundle)
+	return json.Marshal(bundle)
+}
@@ -0,0 +1,298 @@
+// Observability for sgx-entrypoint.
+//
+// The quote path is the primary signal operators have that attestation is
+// actually working — a broken /dev/attestation/* surface otherwise only
+// shows up as HTTP 500s in the logs. This file publishes counters and
+// gauges through expvar at /debug/vars, plus a hand-rolled Prometheus
+// text-format exposition at /metrics, both served from a separate listener
+// so they can be kept off the public quote-service port.
+//
+// Environment:
+//   METRICS_ADDR - bind address for /debug/vars and /metrics (default: disabled)
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	buildVersion = "dev"
+	buildGitSHA  = "unknown"
+)
+
+// latencyHistogram is a simple fixed-bucket cumulative histogram, tracked
+// in milliseconds. It avoids pulling in a metrics client library for a
+// single enclave-local endpoint.
+type latencyHistogram struct {
+	mu      sync.Mutex
+	buckets []float64 // upper bounds, ms, ascending, +Inf implicit last
+	counts  []int64
+	sum     float64
+	total   int64
+}
+
+func newLatencyHistogram(bucketsMs []float64) *latencyHistogram {
+	return &latencyHistogram{
+		buckets: bucketsMs,
+		counts:  make([]int64, len(bucketsMs)+1), // +1 for the +Inf bucket
+	}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sum += ms
+	h.total++
+	for i, bound := range h.buckets {
+		if ms <= bound {
+			h.counts[i]++
+			return
+		}
+	}
+	h.counts[len(h.counts)-1]++
+}
+
+// writePrometheus writes cumulative bucket counts in Prometheus histogram
+// exposition format under the given metric name.
+func (h *latencyHistogram) writePrometheus(w *strings.Builder, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	cumulative := int64(0)
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	cumulative += h.counts[len(h.counts)-1]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %f\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.total)
+}
+
+// reasonCounter is an expvar-style counter bucketed by a string reason,
+// e.g. quote failures bucketed by "not-in-enclave", "read-quote", etc.
+type reasonCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newReasonCounter() *reasonCounter {
+	return &reasonCounter{counts: make(map[string]int64)}
+}
+
+func (c *reasonCounter) Add(reason string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[reason] += delta
+}
+
+// String implements expvar.Var.
+func (c *reasonCounter) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reasons := make([]string, 0, len(c.counts))
+	for reason := range c.counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, reason := range reasons {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%q:%d", reason, c.counts[reason])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (c *reasonCounter) writePrometheus(w *strings.Builder, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	reasons := make([]string, 0, len(c.counts))
+	for reason := range c.counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%s{reason=%q} %d\n", name, reason, c.counts[reason])
+	}
+}
+
+// workerdStatus tracks the supervised workerd child process, updated by
+// main() as it starts, runs, and (if a future revision adds retries)
+// restarts workerd.
+type workerdStatus struct {
+	pid       atomic.Int64
+	startedAt atomic.Int64 // unix nanos
+	restarts  atomic.Int64
+}
+
+func (s *workerdStatus) started(pid int) {
+	s.pid.Store(int64(pid))
+	s.startedAt.Store(time.Now().UnixNano())
+}
+
+func (s *workerdStatus) restarted(pid int) {
+	s.restarts.Add(1)
+	s.started(pid)
+}
+
+func (s *workerdStatus) uptimeSeconds() float64 {
+	started := s.startedAt.Load()
+	if started == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, started)).Seconds()
+}
+
+// serviceMetrics bundles every counter/gauge the quote service publishes.
+type serviceMetrics struct {
+	quoteRequestsTotal  *expvar.Int
+	quoteSuccessTotal   *expvar.Int
+	quoteFailuresTotal  *reasonCounter
+	quoteLatency        *latencyHistogram
+	rateLimitRejections *expvar.Int
+
+	workerd *workerdStatus
+
+	quoteLimiters   *ipLimiterSet
+	healthzLimiters *ipLimiterSet
+}
+
+var metrics = newServiceMetrics()
+
+func newServiceMetrics() *serviceMetrics {
+	m := &serviceMetrics{
+		quoteRequestsTotal:  expvar.NewInt("quoteRequestsTotal"),
+		quoteSuccessTotal:   expvar.NewInt("quoteSuccessTotal"),
+		quoteFailuresTotal:  newReasonCounter(),
+		quoteLatency:        newLatencyHistogram([]float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}),
+		rateLimitRejections: expvar.NewInt("rateLimitRejectionsTotal"),
+		workerd:             &workerdStatus{},
+	}
+
+	expvar.Publish("quoteFailuresTotal", m.quoteFailuresTotal)
+	expvar.Publish("enclave", expvar.Func(func() interface{} { return activeBackend != nil && activeBackend.Available() }))
+	expvar.Publish("backend", expvar.Func(func() interface{} {
+		if activeBackend == nil {
+			return ""
+		}
+		return activeBackend.Kind()
+	}))
+	expvar.Publish("attestationType", expvar.Func(func() interface{} {
+		if activeBackend == nil {
+			return ""
+		}
+		return activeBackend.AttestationType()
+	}))
+	expvar.Publish("workerdPid", expvar.Func(func() interface{} { return m.workerd.pid.Load() }))
+	expvar.Publish("workerdUptimeSeconds", expvar.Func(func() interface{} { return m.workerd.uptimeSeconds() }))
+	expvar.Publish("workerdRestarts", expvar.Func(func() interface{} { return m.workerd.restarts.Load() }))
+	expvar.Publish("quoteLimiterLiveCount", expvar.Func(func() interface{} {
+		if m.quoteLimiters == nil {
+			return 0
+		}
+		return m.quoteLimiters.count()
+	}))
+	expvar.Publish("healthzLimiterLiveCount", expvar.Func(func() interface{} {
+		if m.healthzLimiters == nil {
+			return 0
+		}
+		return m.healthzLimiters.count()
+	}))
+
+	expvar.NewString("goVersion").Set(runtime.Version())
+	expvar.NewString("version").Set(buildVersion)
+	expvar.NewString("gitSha").Set(buildGitSHA)
+	expvar.NewString("startTime").Set(time.Now().UTC().Format(time.RFC3339))
+
+	return m
+}
+
+// bindLimiterSets wires the live per-IP limiter gauges to the quoteService
+// created at startup; newServiceMetrics runs before any quoteService exists.
+func (m *serviceMetrics) bindLimiterSets(qs *quoteService) {
+	m.quoteLimiters = qs.quoteLimiters
+	m.healthzLimiters = qs.healthzLimiters
+}
+
+// metricsHandler renders all metrics in Prometheus text exposition format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# TYPE quote_requests_total counter\nquote_requests_total %d\n", metrics.quoteRequestsTotal.Value())
+	fmt.Fprintf(&b, "# TYPE quote_success_total counter\nquote_success_total %d\n", metrics.quoteSuccessTotal.Value())
+	metrics.quoteFailuresTotal.writePrometheus(&b, "quote_failures_total")
+	metrics.quoteLatency.writePrometheus(&b, "quote_latency_ms")
+	fmt.Fprintf(&b, "# TYPE rate_limit_rejections_total counter\nrate_limit_rejections_total %d\n", metrics.rateLimitRejections.Value())
+
+	if metrics.quoteLimiters != nil {
+		fmt.Fprintf(&b, "# TYPE quote_limiter_live_count gauge\nquote_limiter_live_count %d\n", metrics.quoteLimiters.count())
+	}
+	if metrics.healthzLimiters != nil {
+		fmt.Fprintf(&b, "# TYPE healthz_limiter_live_count gauge\nhealthz_limiter_live_count %d\n", metrics.healthzLimiters.count())
+	}
+
+	enclave := 0
+	backendKind := ""
+	if activeBackend != nil {
+		backendKind = activeBackend.Kind()
+		if activeBackend.Available() {
+			enclave = 1
+		}
+	}
+	fmt.Fprintf(&b, "# TYPE enclave gauge\nenclave{backend=%q} %d\n", backendKind, enclave)
+
+	fmt.Fprintf(&b, "# TYPE workerd_pid gauge\nworkerd_pid %d\n", metrics.workerd.pid.Load())
+	fmt.Fprintf(&b, "# TYPE workerd_uptime_seconds gauge\nworkerd_uptime_seconds %f\n", metrics.workerd.uptimeSeconds())
+	fmt.Fprintf(&b, "# TYPE workerd_restarts_total counter\nworkerd_restarts_total %d\n", metrics.workerd.restarts.Load())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// startMetricsServer starts the /debug/vars + /metrics listener on addr.
+// Returns nil if addr is empty, since the endpoint is opt-in: it's ops
+// surface, not something that belongs on the public listener by default.
+func startMetricsServer(addr string) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", metricsHandler)
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		log.Printf("[sgx-entrypoint] Metrics listening on http://%s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[sgx-entrypoint] Metrics server error: %v", err)
+		}
+	}()
+
+	return server
+}
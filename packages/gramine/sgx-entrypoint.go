@@ -1,18 +1,28 @@
 // SGX Entrypoint for Gramine
 //
 // This binary serves as the entrypoint for Gramine SGX enclaves. It:
-// 1. Starts an HTTP server for SGX quote generation (attestation)
+// 1. Starts an HTTP server for quote generation (attestation)
 // 2. Launches workerd as a child process
 // 3. Forwards signals to workerd for graceful shutdown
 //
-// The quote service uses Gramine's /dev/attestation pseudo-filesystem
-// to generate DCAP quotes for remote attestation.
+// Quote generation is dispatched to a TEEBackend (see backend.go), so the
+// same binary can run under Gramine-SGX, TDX, or (for CI / local dev) a
+// deterministic mock. The backend is selected automatically by probing,
+// or pinned via TEE_BACKEND.
 //
 // Usage:
 //   sgx-entrypoint [--port PORT] -- workerd_args...
 //
 // Environment:
+//   TEE_BACKEND        - Force a backend: sgx|tdx|mock (default: probed)
 //   QUOTE_SERVICE_PORT - Port for the quote service (default: 3333)
+//   QUOTE_RATE         - Sustained /quote requests/sec per IP (default: 0.1, i.e. 6/min)
+//   QUOTE_BURST        - Burst size for /quote per IP (default: 3)
+//   HEALTHZ_RATE       - Sustained /healthz requests/sec per IP (default: 5)
+//   HEALTHZ_BURST      - Burst size for /healthz per IP (default: 20)
+//   GLOBAL_QUOTE_RATE  - Sustained /quote requests/sec across all callers (default: 2)
+//   GLOBAL_QUOTE_BURST - Burst size for the global /quote limiter (default: 10)
+//   METRICS_ADDR       - Bind address for /debug/vars and /metrics (default: disabled)
 
 package main
 
@@ -20,6 +30,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -32,84 +43,219 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 const (
-	defaultPort                = 3333
-	attestationUserReportData  = "/dev/attestation/user_report_data"
-	attestationQuote           = "/dev/attestation/quote"
-	attestationType            = "/dev/attestation/attestation_type"
-	rateLimitWindowMs          = 60_000  // 1 minute window
-	rateLimitMaxRequests       = 10      // max 10 quote requests per minute per IP
-	rateLimitCleanupIntervalMs = 300_000 // cleanup old entries every 5 minutes
+	defaultPort = 3333
+
+	attestationUserReportData = "/dev/attestation/user_report_data"
+	attestationQuote          = "/dev/attestation/quote"
+	attestationType           = "/dev/attestation/attestation_type"
+
+	// Defaults for the per-IP token buckets, expressed as requests/sec and
+	// burst size. /quote is CPU-intensive (it triggers SGX EENTER/EEXIT) so
+	// it gets a much stricter limit than /healthz.
+	defaultQuoteRate    = 0.1 // 6/min
+	defaultQuoteBurst   = 3
+	defaultHealthzRate  = 5
+	defaultHealthzBurst = 20
+
+	// Defaults for the global /quote limiter, which protects the enclave as
+	// a whole from attestation thundering herds regardless of source IP.
+	defaultGlobalQuoteRate  = 2
+	defaultGlobalQuoteBurst = 10
+
+	// Per-IP limiters are evicted once they've sat fully replenished for
+	// longer than this, so rarely-seen IPs don't linger in the map forever.
+	limiterIdleTTL       = 10 * time.Minute
+	limiterSweepInterval = 5 * time.Minute
 
 	// Default workerd binary path
 	defaultWorkerdPath = "/usr/local/bin/workerd"
 )
 
-type rateLimitEntry struct {
-	count       int
-	windowStart int64
+// routeLimits bundles the per-IP rate/burst configuration for a single route.
+type routeLimits struct {
+	rate  rate.Limit
+	burst int
 }
 
-type quoteService struct {
-	rateLimitMap map[string]*rateLimitEntry
-	mu           sync.RWMutex
+// limiterEntry tracks a per-IP limiter along with the time the sweeper last
+// saw it busy (or first saw it fully replenished), so the sweeper can evict
+// it once it's been idle long enough that keeping it around no longer buys
+// anything.
+type limiterEntry struct {
+	limiter       *rate.Limiter
+	replenishedAt time.Time
 }
 
-func newQuoteService() *quoteService {
-	qs := &quoteService{
-		rateLimitMap: make(map[string]*rateLimitEntry),
+// ipLimiterSet is a per-route map of per-IP limiters, swept periodically to
+// bound memory usage.
+type ipLimiterSet struct {
+	mu       sync.RWMutex
+	limiters map[string]*limiterEntry
+	limits   routeLimits
+}
+
+func newIPLimiterSet(limits routeLimits) *ipLimiterSet {
+	return &ipLimiterSet{
+		limiters: make(map[string]*limiterEntry),
+		limits:   limits,
 	}
+}
 
-	// Start periodic cleanup
-	go func() {
-		ticker := time.NewTicker(rateLimitCleanupIntervalMs * time.Millisecond)
-		defer ticker.Stop()
-		for range ticker.C {
-			qs.cleanupRateLimitEntries()
+// get returns the limiter for ip, creating it if necessary.
+func (s *ipLimiterSet) get(ip string) *rate.Limiter {
+	s.mu.RLock()
+	entry, ok := s.limiters[ip]
+	s.mu.RUnlock()
+	if ok {
+		return entry.limiter
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.limiters[ip]; ok {
+		return entry.limiter
+	}
+	limiter := rate.NewLimiter(s.limits.rate, s.limits.burst)
+	s.limiters[ip] = &limiterEntry{limiter: limiter}
+	return limiter
+}
+
+// sweep evicts limiters that have been fully replenished for longer than
+// limiterIdleTTL.
+func (s *ipLimiterSet) sweep() {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ip, entry := range s.limiters {
+		if entry.limiter.TokensAt(now) < float64(s.limits.burst) {
+			// Still draining down from a burst; touch replenishedAt so the
+			// TTL is measured from the last time it was actually busy.
+			entry.replenishedAt = now
+			continue
 		}
-	}()
+		if entry.replenishedAt.IsZero() {
+			entry.replenishedAt = now
+			continue
+		}
+		if now.Sub(entry.replenishedAt) > limiterIdleTTL {
+			delete(s.limiters, ip)
+		}
+	}
+}
 
-	return qs
+// count returns the number of per-IP limiters currently tracked.
+func (s *ipLimiterSet) count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.limiters)
 }
 
-// Check if a request should be rate limited
-func (qs *quoteService) isRateLimited(ip string) bool {
-	qs.mu.Lock()
-	defer qs.mu.Unlock()
+// rateLimitConfig is the resolved rate-limit configuration, read once from
+// env vars at startup.
+type rateLimitConfig struct {
+	quote       routeLimits
+	healthz     routeLimits
+	globalQuote routeLimits
+}
 
-	now := time.Now().UnixMilli()
-	entry, exists := qs.rateLimitMap[ip]
+func loadRateLimitConfig() rateLimitConfig {
+	return rateLimitConfig{
+		quote:       routeLimits{rate: envRate("QUOTE_RATE", defaultQuoteRate), burst: envBurst("QUOTE_BURST", defaultQuoteBurst)},
+		healthz:     routeLimits{rate: envRate("HEALTHZ_RATE", defaultHealthzRate), burst: envBurst("HEALTHZ_BURST", defaultHealthzBurst)},
+		globalQuote: routeLimits{rate: envRate("GLOBAL_QUOTE_RATE", defaultGlobalQuoteRate), burst: envBurst("GLOBAL_QUOTE_BURST", defaultGlobalQuoteBurst)},
+	}
+}
 
-	if !exists || now-entry.windowStart > rateLimitWindowMs {
-		// New window
-		qs.rateLimitMap[ip] = &rateLimitEntry{
-			count:       1,
-			windowStart: now,
+func envRate(name string, fallback float64) rate.Limit {
+	if v := os.Getenv(name); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			return rate.Limit(f)
 		}
-		return false
+		log.Printf("[sgx-entrypoint] invalid %s=%q, using default %v", name, v, fallback)
 	}
+	return rate.Limit(fallback)
+}
 
-	if entry.count >= rateLimitMaxRequests {
-		return true
+func envBurst(name string, fallback int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("[sgx-entrypoint] invalid %s=%q, using default %d", name, v, fallback)
 	}
+	return fallback
+}
 
-	entry.count++
-	return false
+// rateLimitResult carries the outcome of a rate-limit check, including the
+// values needed to populate X-RateLimit-* / Retry-After headers.
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	resetIn    time.Duration
+	retryAfter time.Duration
 }
 
-// Clean up expired rate limit entries
-func (qs *quoteService) cleanupRateLimitEntries() {
-	qs.mu.Lock()
-	defer qs.mu.Unlock()
+// checkRoute reserves a token from both the per-IP and (optionally) the
+// global limiter for a route, rolling back the reservation if either one
+// would have to wait.
+func checkRoute(perIP *ipLimiterSet, global *rate.Limiter, ip string) rateLimitResult {
+	limiter := perIP.get(ip)
+	now := time.Now()
 
-	now := time.Now().UnixMilli()
-	for ip, entry := range qs.rateLimitMap {
-		if now-entry.windowStart > rateLimitWindowMs {
-			delete(qs.rateLimitMap, ip)
+	ipRes := limiter.ReserveN(now, 1)
+	if !ipRes.OK() {
+		return rateLimitResult{allowed: false}
+	}
+	ipDelay := ipRes.DelayFrom(now)
+	if ipDelay > 0 {
+		// Already over the per-IP quota: reject without ever touching the
+		// global bucket, or a single over-quota IP could burn the shared
+		// quota on requests that were going to be rejected anyway.
+		ipRes.Cancel()
+		return rateLimitResult{allowed: false, retryAfter: ipDelay}
+	}
+
+	if global != nil {
+		globalRes := global.ReserveN(now, 1)
+		if !globalRes.OK() {
+			ipRes.Cancel()
+			return rateLimitResult{allowed: false}
+		}
+		globalDelay := globalRes.DelayFrom(now)
+		if globalDelay > 0 {
+			ipRes.Cancel()
+			globalRes.Cancel()
+			return rateLimitResult{allowed: false, retryAfter: globalDelay}
 		}
 	}
+
+	return rateLimitResult{
+		allowed:   true,
+		remaining: int(limiter.TokensAt(now)),
+		resetIn:   time.Duration(float64(time.Second) / float64(limiter.Limit())),
+	}
+}
+
+// setRateLimitHeaders writes X-RateLimit-Remaining / X-RateLimit-Reset, and
+// Retry-After when the request was rejected.
+func setRateLimitHeaders(w http.ResponseWriter, res rateLimitResult) {
+	if res.allowed {
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(res.resetIn.Seconds())))
+		return
+	}
+	retryAfter := res.retryAfter
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	w.Header().Set("X-RateLimit-Remaining", "0")
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.999)))
 }
 
 // Check if we're running inside a Gramine SGX enclave
@@ -127,30 +273,68 @@ func getAttestationType() string {
 	return string(data)
 }
 
+type quoteService struct {
+	cfg             rateLimitConfig
+	quoteLimiters   *ipLimiterSet
+	healthzLimiters *ipLimiterSet
+	globalQuote     *rate.Limiter
+	challenges      *challengeStore
+	quoteCache      *quoteCache
+}
+
+func newQuoteService() *quoteService {
+	cfg := loadRateLimitConfig()
+
+	qs := &quoteService{
+		cfg:             cfg,
+		quoteLimiters:   newIPLimiterSet(cfg.quote),
+		healthzLimiters: newIPLimiterSet(cfg.healthz),
+		globalQuote:     rate.NewLimiter(cfg.globalQuote.rate, cfg.globalQuote.burst),
+		challenges:      newChallengeStore(),
+		quoteCache:      newQuoteCache(quoteCacheCapacity, quoteCacheTTL),
+	}
+
+	// Start periodic cleanup of idle per-IP limiters
+	go func() {
+		ticker := time.NewTicker(limiterSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			qs.quoteLimiters.sweep()
+			qs.healthzLimiters.sweep()
+		}
+	}()
+
+	return qs
+}
+
 type sgxQuoteData struct {
 	Quote      []byte `json:"quote"`
 	ReportData []byte `json:"report_data"`
 }
 
-// Generate an SGX DCAP quote with the given report data
-func generateSgxQuote(reportData []byte) (*sgxQuoteData, error) {
-	if len(reportData) != 64 {
-		return nil, fmt.Errorf("report_data must be exactly 64 bytes, got %d", len(reportData))
-	}
+// quoteError wraps a quote-generation failure with a short, stable reason
+// code so callers (metrics, logging) can bucket errors without parsing
+// error strings.
+type quoteError struct {
+	reason string
+	err    error
+}
 
-	if !isGramineEnclave() {
-		return nil, fmt.Errorf("not running inside a Gramine SGX enclave")
-	}
+func (e *quoteError) Error() string { return e.err.Error() }
+func (e *quoteError) Unwrap() error { return e.err }
 
-	// Write report_data to trigger quote generation
-	if err := os.WriteFile(attestationUserReportData, reportData, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write report_data: %w", err)
+// Generate a quote from the active TEE backend with the given report data
+func generateQuote(reportData []byte) (*sgxQuoteData, error) {
+	if len(reportData) != 64 {
+		return nil, &quoteError{"invalid-report-data", fmt.Errorf("report_data must be exactly 64 bytes, got %d", len(reportData))}
 	}
 
-	// Read the generated quote
-	quote, err := os.ReadFile(attestationQuote)
+	var rd [64]byte
+	copy(rd[:], reportData)
+
+	quote, err := activeBackend.Quote(rd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read quote: %w", err)
+		return nil, err
 	}
 
 	return &sgxQuoteData{
@@ -159,13 +343,25 @@ func generateSgxQuote(reportData []byte) (*sgxQuoteData, error) {
 	}, nil
 }
 
-// Generate a quote bound to an x25519 public key
+// quoteErrorReason extracts the bucketed reason code from an error returned
+// by generateQuote/generateKeyBoundQuote, for use in metrics.
+func quoteErrorReason(err error) string {
+	var qe *quoteError
+	if errors.As(err, &qe) {
+		return qe.reason
+	}
+	return "unknown"
+}
+
+// Generate a quote bound to an x25519 public key and freshness nonce
 //
 // The binding follows the same pattern as the TDX quote service:
 // report_data[0:32] = SHA256(public_key)
-// report_data[32:64] = zeros (or additional binding data)
-func generateKeyBoundQuote(x25519PublicKey []byte) (*sgxQuoteData, error) {
-	// Create report_data with key binding
+// report_data[32:64] = nonce, zero-padded, or SHA256(nonce) if it's longer
+// than 32 bytes. A zero nonce means the quote is unbound to any freshness
+// challenge (replayable for the lifetime of the key, as before).
+func generateKeyBoundQuote(x25519PublicKey, nonce []byte) (*sgxQuoteData, error) {
+	// Create report_data with key + freshness binding
 	reportData := make([]byte, 64)
 
 	if len(x25519PublicKey) > 0 {
@@ -173,9 +369,12 @@ func generateKeyBoundQuote(x25519PublicKey []byte) (*sgxQuoteData, error) {
 		hash := sha256.Sum256(x25519PublicKey)
 		copy(reportData[:32], hash[:])
 	}
-	// Second 32 bytes remain zeros (could be used for additional binding)
+	if len(nonce) > 0 {
+		bound := boundNonce(nonce)
+		copy(reportData[32:], bound[:])
+	}
 
-	return generateSgxQuote(reportData)
+	return generateQuote(reportData)
 }
 
 // HTTP handlers
@@ -191,14 +390,26 @@ func (qs *quoteService) healthzHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	inEnclave := isGramineEnclave()
-	attType := getAttestationType()
+	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if clientIP == "" {
+		clientIP = "unknown"
+	}
+
+	res := checkRoute(qs.healthzLimiters, nil, clientIP)
+	setRateLimitHeaders(w, res)
+	if !res.allowed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "rate limit exceeded"})
+		return
+	}
 
 	response := map[string]interface{}{
 		"status":           "ok",
 		"service":          "sgx-entrypoint",
-		"enclave":          inEnclave,
-		"attestation_type": attType,
+		"backend":          activeBackend.Kind(),
+		"enclave":          activeBackend.Available(),
+		"attestation_type": activeBackend.AttestationType(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -221,24 +432,33 @@ func (qs *quoteService) quoteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Rate limiting for quote generation (CPU-intensive operation)
+	// Rate limiting for quote generation (CPU-intensive operation): both a
+	// per-IP token bucket and a global bucket protecting the enclave as a
+	// whole from attestation thundering herds.
 	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
 	if clientIP == "" {
 		clientIP = "unknown"
 	}
 
-	if qs.isRateLimited(clientIP) {
+	res := checkRoute(qs.quoteLimiters, qs.globalQuote, clientIP)
+	setRateLimitHeaders(w, res)
+	if !res.allowed {
+		metrics.rateLimitRejections.Add(1)
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Retry-After", "60")
 		w.WriteHeader(http.StatusTooManyRequests)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error":   "rate limit exceeded",
-			"message": fmt.Sprintf("Maximum %d quote requests per minute", rateLimitMaxRequests),
+			"message": fmt.Sprintf("Maximum %v quote requests/sec per client (burst %d)", qs.cfg.quote.rate, qs.cfg.quote.burst),
 		})
 		return
 	}
 
-	var publicKey []byte
+	metrics.quoteRequestsTotal.Add(1)
+	start := time.Now()
+
+	var publicKey, nonce []byte
+	var challengeID string
+	wantCollateral := r.URL.Query().Get("collateral") == "1"
 
 	if r.Method == http.MethodPost {
 		// Read request body
@@ -252,7 +472,10 @@ func (qs *quoteService) quoteHandler(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
 		var data struct {
-			PublicKey []byte `json:"publicKey"`
+			PublicKey   []byte `json:"publicKey"`
+			Nonce       []byte `json:"nonce"`
+			ChallengeID string `json:"challenge_id"`
+			Collateral  bool   `json:"collateral"`
 		}
 
 		if len(body) > 0 {
@@ -263,48 +486,129 @@ func (qs *quoteService) quoteHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			publicKey = data.PublicKey
+			nonce = data.Nonce
+			challengeID = data.ChallengeID
+			wantCollateral = wantCollateral || data.Collateral
 		}
 	}
 
-	// Check if we're in an enclave
-	if !isGramineEnclave() {
+	if challengeID != "" {
+		redeemed, ok := qs.challenges.redeem(challengeID)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGone)
+			json.NewEncoder(w).Encode(map[string]string{"error": "challenge_id expired or already used"})
+			return
+		}
+		nonce = redeemed
+	}
+
+	// Check that the active backend's attestation surface is usable
+	if !activeBackend.Available() {
+		metrics.quoteFailuresTotal.Add("not-in-enclave", 1)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotImplemented)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Not running inside SGX enclave",
-			"hint":  "Run with: gramine-sgx workerd ...",
+			"error": fmt.Sprintf("%s backend not available", activeBackend.Kind()),
+			"hint":  "Run with: gramine-sgx workerd ..., or set TEE_BACKEND=mock for local dev",
 		})
 		return
 	}
 
-	// Generate the quote
-	quoteData, err := generateKeyBoundQuote(publicKey)
-	if err != nil {
-		log.Printf("[sgx-entrypoint] Quote generation error: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
+	// Serve from the (publicKey, nonce) LRU if a burst already produced
+	// this exact quote; SGX quote generation is expensive enough that the
+	// route is already rate-limited around it.
+	cacheKey := quoteCacheKey(publicKey, nonce)
+	quoteData, cached := qs.quoteCache.get(cacheKey)
+	if !cached {
+		var err error
+		quoteData, err = generateKeyBoundQuote(publicKey, nonce)
+		if err != nil {
+			metrics.quoteFailuresTotal.Add(quoteErrorReason(err), 1)
+			log.Printf("[sgx-entrypoint] Quote generation error: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		qs.quoteCache.put(cacheKey, quoteData)
 	}
 
+	metrics.quoteSuccessTotal.Add(1)
+	metrics.quoteLatency.observe(time.Since(start))
+
 	// Return as JSON with base64-encoded values (same format as TDX service)
-	response := map[string]string{
+	response := map[string]interface{}{
 		"quote":       base64.StdEncoding.EncodeToString(quoteData.Quote),
 		"tee_type":    "sgx",
 		"report_data": base64.StdEncoding.EncodeToString(quoteData.ReportData),
 	}
+	if len(nonce) > 0 {
+		response["nonce"] = base64.StdEncoding.EncodeToString(nonce)
+	}
+	if challengeID != "" {
+		response["challenge_id"] = challengeID
+	}
+	if wantCollateral {
+		raw, err := activeBackend.CollateralBundle(quoteData.Quote)
+		if err != nil {
+			log.Printf("[sgx-entrypoint] Collateral unavailable: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("collateral unavailable: %v", err)})
+			return
+		}
+		response["collateral"] = json.RawMessage(raw)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// challengeHandler issues a short-lived, server-generated freshness nonce.
+// A client redeems it via POST /quote {"challenge_id": ...} instead of
+// supplying its own nonce, which rules out a client-chosen nonce that was
+// itself a replay.
+func (qs *quoteService) challengeHandler(w http.ResponseWriter, r *http.Request) {
+	// Enable CORS
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, nonce, err := qs.challenges.issue()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"challenge_id": id,
+		"nonce":        base64.StdEncoding.EncodeToString(nonce),
+		"expires_in":   int(challengeTTL.Seconds()),
+	})
+}
+
 // startQuoteService starts the HTTP server for quote generation
 func startQuoteService(port int) *http.Server {
 	qs := newQuoteService()
+	metrics.bindLimiterSets(qs)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", qs.healthzHandler)
 	mux.HandleFunc("/quote", qs.quoteHandler)
+	mux.HandleFunc("/challenge", qs.challengeHandler)
 
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
 	server := &http.Server{
@@ -353,9 +657,10 @@ func main() {
 	// The entrypoint is called by Gramine with: sgx-entrypoint serve --experimental ...
 	workerdArgs := os.Args[1:]
 
+	activeBackend = selectBackend()
+
 	log.Printf("[sgx-entrypoint] Starting SGX entrypoint")
-	log.Printf("[sgx-entrypoint] Enclave: %v", isGramineEnclave())
-	log.Printf("[sgx-entrypoint] Attestation type: %s", getAttestationType())
+	log.Printf("[sgx-entrypoint] TEE backend: %s (available: %v)", activeBackend.Kind(), activeBackend.Available())
 
 	// Start the quote service
 	server := startQuoteService(port)
@@ -366,6 +671,10 @@ func main() {
 	}
 	log.Printf("[sgx-entrypoint] Quote service ready on port %d", port)
 
+	// Start the metrics endpoint, if configured. Kept on its own listener
+	// so it can be left off the public port.
+	metricsServer := startMetricsServer(os.Getenv("METRICS_ADDR"))
+
 	// Start workerd
 	workerdPath := defaultWorkerdPath
 	if path := os.Getenv("WORKERD_PATH"); path != "" {
@@ -389,6 +698,7 @@ func main() {
 	}
 
 	log.Printf("[sgx-entrypoint] workerd started with PID %d", cmd.Process.Pid)
+	metrics.workerd.started(cmd.Process.Pid)
 
 	// Wait for either workerd to exit or a signal
 	done := make(chan error, 1)
@@ -412,8 +722,11 @@ func main() {
 		}
 	}
 
-	// Shutdown the quote service gracefully
+	// Shutdown the quote service and metrics endpoint gracefully
 	server.Close()
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
 
 	// Exit with the same code as workerd if possible
 	if cmd.ProcessState != nil {
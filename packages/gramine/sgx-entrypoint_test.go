@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestQuoteService builds a quoteService against the mock TEE backend,
+// so the handler logic can be exercised without real SGX hardware.
+func newTestQuoteService(t *testing.T) *quoteService {
+	t.Helper()
+	activeBackend = &mockBackend{}
+	return newQuoteService()
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response body %q: %v", rec.Body.String(), err)
+	}
+	return body
+}
+
+func TestHealthzHandler(t *testing.T) {
+	qs := newTestQuoteService(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+
+	qs.healthzHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := decodeJSON(t, rec)
+	if body["backend"] != "mock" {
+		t.Errorf("backend = %v, want %q", body["backend"], "mock")
+	}
+	if body["enclave"] != true {
+		t.Errorf("enclave = %v, want true", body["enclave"])
+	}
+	if body["attestation_type"] != "mock" {
+		t.Errorf("attestation_type = %v, want %q", body["attestation_type"], "mock")
+	}
+}
+
+func TestQuoteHandlerChallengeBinding(t *testing.T) {
+	qs := newTestQuoteService(t)
+
+	challengeReq := httptest.NewRequest(http.MethodGet, "/challenge", nil)
+	challengeReq.RemoteAddr = "10.0.0.2:1234"
+	challengeRec := httptest.NewRecorder()
+	qs.challengeHandler(challengeRec, challengeReq)
+
+	if challengeRec.Code != http.StatusOK {
+		t.Fatalf("challenge status = %d, want %d", challengeRec.Code, http.StatusOK)
+	}
+	challenge := decodeJSON(t, challengeRec)
+	challengeID, _ := challenge["challenge_id"].(string)
+	wantNonce, _ := challenge["nonce"].(string)
+	if challengeID == "" || wantNonce == "" {
+		t.Fatalf("challenge response missing challenge_id/nonce: %v", challenge)
+	}
+
+	quoteBody := `{"challenge_id":"` + challengeID + `"}`
+	quoteReq := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(quoteBody))
+	quoteReq.RemoteAddr = "10.0.0.2:1234"
+	quoteRec := httptest.NewRecorder()
+	qs.quoteHandler(quoteRec, quoteReq)
+
+	if quoteRec.Code != http.StatusOK {
+		t.Fatalf("quote status = %d, body = %s", quoteRec.Code, quoteRec.Body.String())
+	}
+	quote := decodeJSON(t, quoteRec)
+	if quote["nonce"] != wantNonce {
+		t.Errorf("quote nonce = %v, want %v (the nonce minted by /challenge)", quote["nonce"], wantNonce)
+	}
+
+	reportData, err := base64.StdEncoding.DecodeString(quote["report_data"].(string))
+	if err != nil {
+		t.Fatalf("decoding report_data: %v", err)
+	}
+	nonceBytes, _ := base64.StdEncoding.DecodeString(wantNonce)
+	wantBound := boundNonce(nonceBytes)
+	if string(reportData[32:]) != string(wantBound[:]) {
+		t.Errorf("report_data[32:] doesn't match the bound challenge nonce")
+	}
+
+	// A second redemption of the same challenge_id must fail: it's already
+	// been consumed, so the freshness binding can't be replayed.
+	replayReq := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(quoteBody))
+	replayReq.RemoteAddr = "10.0.0.2:1234"
+	replayRec := httptest.NewRecorder()
+	qs.quoteHandler(replayRec, replayReq)
+	if replayRec.Code != http.StatusGone {
+		t.Errorf("replayed challenge_id status = %d, want %d", replayRec.Code, http.StatusGone)
+	}
+}
+
+// TestQuoteHandlerCollateral covers the POST /quote?collateral=1 path
+// against the mock backend, which bundles a fixed {"mock":true} collateral
+// blob rather than talking to Gramine or a PCCS.
+func TestQuoteHandlerCollateral(t *testing.T) {
+	qs := newTestQuoteService(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/quote?collateral=1", strings.NewReader(`{}`))
+	req.RemoteAddr = "10.0.0.5:1234"
+	rec := httptest.NewRecorder()
+	qs.quoteHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	body := decodeJSON(t, rec)
+	collateral, ok := body["collateral"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("collateral = %v, want a JSON object", body["collateral"])
+	}
+	if collateral["mock"] != true {
+		t.Errorf("collateral = %v, want {\"mock\":true}", collateral)
+	}
+}
+
+// TestQuoteHandlerRateLimitDoesNotDrainGlobalBucket reproduces the scenario
+// from the chunk0-1 review: a single IP well past its own per-IP quota must
+// be rejected locally without ever reserving from the shared global bucket,
+// so it can't starve other callers of the global quota.
+func TestQuoteHandlerRateLimitDoesNotDrainGlobalBucket(t *testing.T) {
+	t.Setenv("QUOTE_RATE", "0.001")
+	t.Setenv("QUOTE_BURST", "1")
+	t.Setenv("GLOBAL_QUOTE_RATE", "0.001")
+	t.Setenv("GLOBAL_QUOTE_BURST", "5")
+	qs := newTestQuoteService(t)
+
+	offender := "10.0.0.3:1234"
+
+	// First request consumes the offending IP's sole burst token (and one
+	// global token alongside it).
+	req := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(`{}`))
+	req.RemoteAddr = offender
+	rec := httptest.NewRecorder()
+	qs.quoteHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// Every subsequent request from the same IP is over quota and must be
+	// rejected without touching the global limiter.
+	for i := 0; i < 7; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(`{}`))
+		req.RemoteAddr = offender
+		rec := httptest.NewRecorder()
+		qs.quoteHandler(rec, req)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("rejected request %d status = %d, want %d", i, rec.Code, http.StatusTooManyRequests)
+		}
+	}
+
+	// A different, well-behaved IP should still find the global bucket
+	// close to full: only the one successful request above should have
+	// reserved from it, not the seven that followed.
+	other := "10.0.0.4:1234"
+	req = httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(`{}`))
+	req.RemoteAddr = other
+	rec = httptest.NewRecorder()
+	qs.quoteHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("other IP's request status = %d, want %d (global bucket was drained by the offending IP)", rec.Code, http.StatusOK)
+	}
+}